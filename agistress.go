@@ -13,16 +13,24 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"math"
+	"math/rand"
 	"net"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 )
 
@@ -33,19 +41,39 @@ const (
 	failure      = "FAILURE"
 )
 
+const (
+	histResolution = 16 // sub-buckets per power of two
+	histMaxPower   = 60 // covers durations up to ~2^60 ns
+	histBuckets    = histMaxPower * histResolution
+)
+
 var (
-	conf   = flag.String("conf", "", "Configuration file")
-	single = flag.Bool("single", false, "Connect and run only once")
-	debug  = flag.Bool("debug", false, "Write detailed statistics to csv file")
-	host   = flag.String("host", "127.0.0.1", "FAstAGI server host")
-	port   = flag.String("port", "4573", "FastAGI server port")
-	runs   = flag.Float64("runs", 1, "Number of runs per second")
-	sess   = flag.Int("sess", 1, "Sessions per run")
-	delay  = flag.Int("delay", 50, "Delay in AGI responses to the server (milliseconds)")
-	req    = flag.String("req", "myagi?file=echo-test", "AGI request")
-	cid    = flag.String("cid", "Unknown", "Caller ID")
-	ext    = flag.String("ext", "100", "Called extension")
-	agiTLS = flag.Bool("tls", false, "Enable TLS encryption")
+	conf          = flag.String("conf", "", "Configuration file")
+	single        = flag.Bool("single", false, "Connect and run only once")
+	debug         = flag.Bool("debug", false, "Write detailed statistics to csv file")
+	host          = flag.String("host", "127.0.0.1", "FAstAGI server host")
+	port          = flag.String("port", "4573", "FastAGI server port")
+	runs          = flag.Float64("runs", 1, "Number of runs per second")
+	sess          = flag.Int("sess", 1, "Sessions per run, multiplying --runs into the base arrival rate (overridden by --ramp/--step/--spike's own rates)")
+	delay         = flag.Int("delay", 50, "Delay in AGI responses to the server (milliseconds)")
+	req           = flag.String("req", "myagi?file=echo-test", "AGI request")
+	cid           = flag.String("cid", "Unknown", "Caller ID")
+	ext           = flag.String("ext", "100", "Called extension")
+	agiTLS        = flag.Bool("tls", false, "Enable TLS encryption")
+	tlsCA         = flag.String("tls-ca", "", "PEM file with CA certificates to verify the server against")
+	tlsCert       = flag.String("tls-cert", "", "PEM file with the client certificate (mTLS)")
+	tlsKey        = flag.String("tls-key", "", "PEM file with the client private key (mTLS)")
+	tlsServerName = flag.String("tls-servername", "", "SNI hostname to present, overriding the dialed host")
+	tlsMinVersion = flag.String("tls-min-version", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsInsecure   = flag.Bool("tls-insecure", false, "Skip server certificate verification (INSECURE)")
+	ramp          = flag.String("ramp", "", "Linear ramp load profile: from=<rate>,to=<rate>,over=<duration>")
+	step          = flag.String("step", "", "Stepped load profile: rates=<r1,r2,...>,dwell=<duration>")
+	spike         = flag.String("spike", "", "Spike load profile: base=<rate>,peak=<rate>,period=<duration>")
+	retry         = flag.String("retry", "", "Retry policy on dial failure: max-attempts=<n>,base-delay=<dur>,max-delay=<dur>,factor=<f>,jitter=<f>")
+	targets       = flag.String("targets", "", "Comma-separated backend targets with optional weights, e.g. host1:port=3,host2:port=1 (defaults to --host/--port)")
+	targetMode    = flag.String("target-mode", "weighted", "Target selection mode: weighted or round-robin")
+	pprofAddr     = flag.String("pprof", "", "Serve net/http/pprof on this address, e.g. :6060 (disabled if empty)")
+	resultsJSON   = flag.String("results-json", "", "Write a structured JSON results report to this file")
 )
 
 // AgiMsg holds the AGI payload data
@@ -56,29 +84,773 @@ type AgiMsg struct {
 
 // Config holds the configuration data
 type Config struct {
-	AgiEnv     []string `json:"env"`     // AGI environment data
-	AgiPayload []AgiMsg `json:"payload"` // AGI payload
+	AgiEnv     []string     `json:"env"`     // AGI environment data
+	AgiPayload []AgiMsg     `json:"payload"` // AGI payload
+	AgiScript  []ScriptStep `json:"script"`  // Scripted, stateful AGI responses
+}
+
+// ScriptStep is one step of a scripted AGI response. Match is a regex (a
+// literal string also works) tested against each command the AGI server
+// sends; named capture groups are copied into Vars for later steps to
+// reference. Reply is a text/template expanded against {{.Vars.name}}.
+type ScriptStep struct {
+	Name   string `json:"name"`   // Optional label, the target of Goto
+	Match  string `json:"match"`  // Regex matched against the server's command
+	Reply  string `json:"reply"`  // Response template
+	Delay  int    `json:"delay"`  // Delay before sending the response (milliseconds)
+	Goto   string `json:"goto"`   // Step name to jump to after replying
+	Repeat int    `json:"repeat"` // Times this step is used before advancing (0 or 1 = once)
 }
 
 // Bench holds the benchmark session data
 type Bench struct {
-	AvrDur     int64         // Average duration for each session
-	Active     int32         // Number of active sessions
-	Count      uint32        // Sessions count
-	Fail       uint32        // Failed sessions count
-	Shutdown   uint32        // Stop switch
-	LogChan    chan string   // Channel for sending logging data
-	TimeChan   chan int64    // Channel used for synchronization
-	RunDelay   time.Duration // Session start delay
-	ReplyDelay time.Duration // AGI response delay
-	Logger     *bufio.Writer // Log file writer
-	Env        []byte        // AGI environment data
-	Payload    []AgiMsg      // AGI payload data
+	TimeHist    *LatencyHistogram // Session duration histogram
+	Profile     LoadProfile       // Arrival rate over the life of the run
+	Started     time.Time         // Time the scheduler started dispatching
+	Active      int32             // Number of active sessions
+	Count       uint32            // Sessions count
+	Fail        uint32            // Failed sessions count
+	Retries     uint32            // Dial attempts beyond the first, across all sessions
+	RetriedFail uint32            // Sessions that failed after exhausting the retry policy
+	Retry       RetryPolicy       // Dial retry policy
+	Shutdown    uint32            // Stop switch
+	LogChan     chan string       // Channel for sending logging data
+	ReplyDelay  time.Duration     // AGI response delay
+	Logger      *bufio.Writer     // Log file writer
+	Env         []byte            // AGI environment data
+	Payload     []AgiMsg          // AGI payload data
+	Script      *scriptState      // Scripted, stateful AGI responses
+	SysStats    atomic.Value      // Latest SysSample, for lock-free reads from the console
+	Targets     *TargetPool       // Backend pool dialed per session
+	TLSConfig   *tls.Config       // TLS client configuration, set when --tls is given
+}
+
+const clockTicksPerSec = 100 // typical Linux USER_HZ; good enough for a rough CPU% estimate
+
+// SysSample is a point-in-time snapshot of the driver's own resource
+// usage, taken once a second so a plateau in Count/s can be attributed to
+// the server or to the driver machine saturating itself.
+type SysSample struct {
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"`
+	Goroutines int     `json:"goroutines"`
+	FDs        int     `json:"fds"`
+	Load1      float64 `json:"load1"`
+	Load5      float64 `json:"load5"`
+	Load15     float64 `json:"load15"`
+}
+
+// sysSampler records a SysSample once a second for the life of the run,
+// publishing the latest one via b.SysStats and, in debug mode, appending a
+// "#sys" row to the CSV log. It reads /proc directly on Linux and falls
+// back to runtime.NumGoroutine/MemStats elsewhere.
+func sysSampler(b *Bench) {
+	prevCPU, cpuOk := readProcStat()
+	prevTime := time.Now()
+	for atomic.LoadUint32(&b.Shutdown) == 0 {
+		time.Sleep(1 * time.Second)
+		now := time.Now()
+		var cpuPct float64
+		if cpu, ok := readProcStat(); ok && cpuOk {
+			if elapsed := now.Sub(prevTime).Seconds(); elapsed > 0 {
+				cpuPct = (cpu - prevCPU) / elapsed * 100 / float64(runtime.NumCPU())
+			}
+			prevCPU = cpu
+		}
+		prevTime = now
+		load1, load5, load15 := readLoadAvg()
+		sample := SysSample{
+			CPUPercent: cpuPct,
+			RSS:        readRSS(),
+			Goroutines: runtime.NumGoroutine(),
+			FDs:        countOpenFDs(),
+			Load1:      load1,
+			Load5:      load5,
+			Load15:     load15,
+		}
+		b.SysStats.Store(sample)
+		if *debug {
+			b.LogChan <- fmt.Sprintf("#sys,%d,%.2f,%d,%d,%d,%.2f,%.2f,%.2f\n",
+				now.Unix(), sample.CPUPercent, sample.RSS, sample.Goroutines, sample.FDs,
+				sample.Load1, sample.Load5, sample.Load15)
+		}
+	}
+}
+
+// readProcStat returns the process's accumulated user+system CPU time in
+// seconds, read from /proc/self/stat. ok is false when /proc is
+// unavailable (e.g. non-Linux), in which case CPU% is left at zero.
+func readProcStat() (seconds float64, ok bool) {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0, false
+	}
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(data)[end+1:])
+	if len(fields) < 13 {
+		return 0, false
+	}
+	utime, err1 := strconv.ParseFloat(fields[11], 64)
+	stime, err2 := strconv.ParseFloat(fields[12], 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	return (utime + stime) / clockTicksPerSec, true
+}
+
+// readLoadAvg reads the 1/5/15 minute load averages from /proc/loadavg,
+// returning zeroes where unavailable.
+func readLoadAvg() (load1, load5, load15 float64) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0
+	}
+	load1, _ = strconv.ParseFloat(fields[0], 64)
+	load5, _ = strconv.ParseFloat(fields[1], 64)
+	load15, _ = strconv.ParseFloat(fields[2], 64)
+	return
+}
+
+// readRSS returns the process's resident set size in bytes, from
+// /proc/self/status on Linux or, elsewhere, an approximation from the Go
+// runtime's own view of memory obtained from the OS.
+func readRSS() uint64 {
+	if data, err := os.ReadFile("/proc/self/status"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(line, "VmRSS:") {
+				continue
+			}
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				if kb, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+					return kb * 1024
+				}
+			}
+		}
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys
+}
+
+// countOpenFDs returns the number of open file descriptors, or zero where
+// /proc is unavailable.
+func countOpenFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// compiledStep is a ScriptStep with its regex and reply template
+// pre-compiled, since it is reused across every connection of the run.
+type compiledStep struct {
+	ScriptStep
+	re   *regexp.Regexp
+	tmpl *template.Template
+}
+
+// scriptState is the compiled form of Config.AgiScript.
+type scriptState struct {
+	steps  []compiledStep
+	byName map[string]int
+}
+
+// compileScript compiles every step's match regex and reply template once
+// up front, and indexes step names for Goto.
+func compileScript(steps []ScriptStep) (*scriptState, error) {
+	s := &scriptState{
+		steps:  make([]compiledStep, len(steps)),
+		byName: make(map[string]int, len(steps)),
+	}
+	for i, step := range steps {
+		re, err := regexp.Compile(step.Match)
+		if err != nil {
+			return nil, fmt.Errorf("script step %d: invalid match regex: %v", i, err)
+		}
+		tmpl, err := template.New(fmt.Sprintf("step%d", i)).Parse(step.Reply)
+		if err != nil {
+			return nil, fmt.Errorf("script step %d: invalid reply template: %v", i, err)
+		}
+		s.steps[i] = compiledStep{ScriptStep: step, re: re, tmpl: tmpl}
+		if step.Name != "" {
+			s.byName[step.Name] = i
+		}
+	}
+	for i, step := range steps {
+		if step.Goto == "" {
+			continue
+		}
+		if _, ok := s.byName[step.Goto]; !ok {
+			return nil, fmt.Errorf("script step %d: goto references unknown step name %q", i, step.Goto)
+		}
+	}
+	return s, nil
+}
+
+// matchStep scans steps from cursor onward for the first whose Match regex
+// matches line, folding any named capture groups into vars.
+func matchStep(s *scriptState, cursor int, line string, vars map[string]string) (compiledStep, int, bool) {
+	for i := cursor; i < len(s.steps); i++ {
+		step := s.steps[i]
+		m := step.re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		for j, name := range step.re.SubexpNames() {
+			if j == 0 || name == "" {
+				continue
+			}
+			vars[name] = m[j]
+		}
+		return step, i, true
+	}
+	return compiledStep{}, 0, false
+}
+
+// expandReply renders a step's reply template against the accumulated
+// Vars, falling back to the raw reply text if expansion fails.
+func expandReply(step compiledStep, vars map[string]string) string {
+	var out strings.Builder
+	data := struct{ Vars map[string]string }{Vars: vars}
+	if err := step.tmpl.Execute(&out, data); err != nil {
+		return step.Reply
+	}
+	return out.String()
+}
+
+// LoadProfile reports the instantaneous target arrival rate, in sessions
+// per second, at a given elapsed time since the scheduler started. The
+// open-model scheduler in agiBench consults it before every arrival.
+type LoadProfile interface {
+	Rate(elapsed time.Duration) float64
+}
+
+// constantProfile fires arrivals at a fixed rate, the previous --runs/--sess
+// behaviour.
+type constantProfile struct {
+	rate float64
+}
+
+func (p constantProfile) Rate(time.Duration) float64 {
+	return p.rate
+}
+
+// rampProfile linearly interpolates the rate from "from" to "to" over the
+// "over" duration, then holds at "to".
+type rampProfile struct {
+	from, to float64
+	over     time.Duration
+}
+
+func (p rampProfile) Rate(elapsed time.Duration) float64 {
+	if elapsed >= p.over {
+		return p.to
+	}
+	frac := float64(elapsed) / float64(p.over)
+	return p.from + frac*(p.to-p.from)
+}
+
+// stepProfile holds each rate in turn for "dwell" before moving to the
+// next, repeating the last rate once the list is exhausted.
+type stepProfile struct {
+	rates []float64
+	dwell time.Duration
+}
+
+func (p stepProfile) Rate(elapsed time.Duration) float64 {
+	idx := int(elapsed / p.dwell)
+	if idx >= len(p.rates) {
+		idx = len(p.rates) - 1
+	}
+	return p.rates[idx]
+}
+
+// spikeProfile holds "base" for most of each "period", bursting to "peak"
+// for the first tenth of it.
+type spikeProfile struct {
+	base, peak float64
+	period     time.Duration
+}
+
+func (p spikeProfile) Rate(elapsed time.Duration) float64 {
+	if elapsed%p.period < p.period/10 {
+		return p.peak
+	}
+	return p.base
+}
+
+// parseKV splits a "k1=v1,k2=v2" (commas and/or spaces) spec into a map.
+// An item with no "=" is appended to the previous key's value, so list
+// values such as "rates=10,50,100" stay whole.
+func parseKV(spec string) map[string]string {
+	spec = strings.NewReplacer(" ", ",").Replace(spec)
+	kv := make(map[string]string)
+	last := ""
+	for _, pair := range strings.Split(spec, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) == 2 {
+			kv[parts[0]] = parts[1]
+			last = parts[0]
+		} else if last != "" {
+			kv[last] += "," + pair
+		}
+	}
+	return kv
+}
+
+// newRampProfile parses a "from=<rate>,to=<rate>,over=<duration>" spec.
+func newRampProfile(spec string) (LoadProfile, error) {
+	kv := parseKV(spec)
+	from, err := strconv.ParseFloat(kv["from"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("ramp: invalid from: %v", err)
+	}
+	to, err := strconv.ParseFloat(kv["to"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("ramp: invalid to: %v", err)
+	}
+	over, err := time.ParseDuration(kv["over"])
+	if err != nil {
+		return nil, fmt.Errorf("ramp: invalid over: %v", err)
+	}
+	return rampProfile{from: from, to: to, over: over}, nil
+}
+
+// newStepProfile parses a "rates=<r1,r2,...>,dwell=<duration>" spec.
+func newStepProfile(spec string) (LoadProfile, error) {
+	kv := parseKV(spec)
+	var rates []float64
+	for _, r := range strings.Split(kv["rates"], ",") {
+		rate, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, fmt.Errorf("step: invalid rate %q: %v", r, err)
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("step: no rates given")
+	}
+	dwell, err := time.ParseDuration(kv["dwell"])
+	if err != nil {
+		return nil, fmt.Errorf("step: invalid dwell: %v", err)
+	}
+	if dwell <= 0 {
+		return nil, fmt.Errorf("step: dwell must be > 0, got %v", dwell)
+	}
+	return stepProfile{rates: rates, dwell: dwell}, nil
+}
+
+// newSpikeProfile parses a "base=<rate>,peak=<rate>,period=<duration>" spec.
+func newSpikeProfile(spec string) (LoadProfile, error) {
+	kv := parseKV(spec)
+	base, err := strconv.ParseFloat(kv["base"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("spike: invalid base: %v", err)
+	}
+	peak, err := strconv.ParseFloat(kv["peak"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("spike: invalid peak: %v", err)
+	}
+	period, err := time.ParseDuration(kv["period"])
+	if err != nil {
+		return nil, fmt.Errorf("spike: invalid period: %v", err)
+	}
+	if period <= 0 {
+		return nil, fmt.Errorf("spike: period must be > 0, got %v", period)
+	}
+	return spikeProfile{base: base, peak: peak, period: period}, nil
+}
+
+// RetryPolicy controls how agiConnection rides out dial failures with
+// exponential backoff and jitter, mirroring standard gRPC backoff
+// behavior, so a briefly overloaded server isn't conflated with one that
+// is refusing connections outright.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Factor      float64
+	Jitter      float64
+}
+
+// defaultRetryPolicy makes a single dial attempt, the previous behaviour.
+var defaultRetryPolicy = RetryPolicy{MaxAttempts: 1, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Factor: 1.6, Jitter: 0.2}
+
+// newRetryPolicy parses a "max-attempts=<n>,base-delay=<dur>,max-delay=<dur>,
+// factor=<f>,jitter=<f>" spec, starting from defaultRetryPolicy so any
+// field left unset keeps its default.
+func newRetryPolicy(spec string) (RetryPolicy, error) {
+	p := defaultRetryPolicy
+	kv := parseKV(spec)
+	if v, ok := kv["max-attempts"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("retry: invalid max-attempts: %v", err)
+		}
+		if n < 1 {
+			return p, fmt.Errorf("retry: max-attempts must be >= 1, got %d", n)
+		}
+		p.MaxAttempts = n
+	}
+	if v, ok := kv["base-delay"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return p, fmt.Errorf("retry: invalid base-delay: %v", err)
+		}
+		p.BaseDelay = d
+	}
+	if v, ok := kv["max-delay"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return p, fmt.Errorf("retry: invalid max-delay: %v", err)
+		}
+		p.MaxDelay = d
+	}
+	if v, ok := kv["factor"]; ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return p, fmt.Errorf("retry: invalid factor: %v", err)
+		}
+		p.Factor = f
+	}
+	if v, ok := kv["jitter"]; ok {
+		j, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return p, fmt.Errorf("retry: invalid jitter: %v", err)
+		}
+		p.Jitter = j
+	}
+	return p, nil
+}
+
+// nextRetryDelay computes min(maxDelay, baseDelay*factor^attempt), then
+// applies uniform jitter of +/-jitter*100%.
+func nextRetryDelay(p RetryPolicy, attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(p.Factor, float64(attempt))
+	if max := float64(p.MaxDelay); delay > max {
+		delay = max
+	}
+	delay *= 1 + p.Jitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
+
+// Target is one backend in a weighted pool, with its own running counters
+// so a small per-backend summary table can be printed on the console.
+type Target struct {
+	Addr   string
+	Weight int
+	Count  uint32
+	Fail   uint32
+	Hist   *LatencyHistogram
+}
+
+// TargetPool selects a backend per session, either weighted-random or
+// round-robin, letting a single driver process benchmark a pool of
+// FastAGI workers or A/B two server versions off a shared ramp/spike
+// schedule.
+type TargetPool struct {
+	targets []*Target
+	mode    string
+	total   int
+	next    uint32 // round-robin cursor
+}
+
+// newTargetPool parses a "host:port[=weight],..." spec. A target with no
+// "=weight" suffix gets weight 1.
+func newTargetPool(spec, mode string) (*TargetPool, error) {
+	pool := &TargetPool{mode: mode}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, weight := item, 1
+		if idx := strings.LastIndex(item, "="); idx >= 0 {
+			addr = item[:idx]
+			w, err := strconv.Atoi(item[idx+1:])
+			if err != nil {
+				return nil, fmt.Errorf("targets: invalid weight in %q: %v", item, err)
+			}
+			if w < 1 {
+				return nil, fmt.Errorf("targets: weight must be >= 1 in %q", item)
+			}
+			weight = w
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("targets: invalid address %q: %v", addr, err)
+		}
+		pool.targets = append(pool.targets, &Target{Addr: addr, Weight: weight, Hist: new(LatencyHistogram)})
+		pool.total += weight
+	}
+	if len(pool.targets) == 0 {
+		return nil, fmt.Errorf("targets: no valid targets in %q", spec)
+	}
+	return pool, nil
+}
+
+// Pick selects the next backend to dial.
+func (p *TargetPool) Pick() *Target {
+	if p.mode == "round-robin" {
+		i := atomic.AddUint32(&p.next, 1) - 1
+		return p.targets[i%uint32(len(p.targets))]
+	}
+	r := rand.Intn(p.total)
+	for _, t := range p.targets {
+		if r < t.Weight {
+			return t
+		}
+		r -= t.Weight
+	}
+	return p.targets[len(p.targets)-1]
+}
+
+// buildTLSConfig assembles a *tls.Config from the --tls-* flags: a
+// RootCAs pool for server verification, an optional client certificate
+// for mTLS, and the SNI hostname. ServerName is left empty unless
+// --tls-servername pins it explicitly; dialTLSConfig fills it in per-dial
+// from the target actually being dialed, since a pool may hold several
+// backends with different hostnames.
+func buildTLSConfig() (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(*tlsMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		InsecureSkipVerify: *tlsInsecure,
+		MinVersion:         minVersion,
+		ServerName:         *tlsServerName,
+	}
+	if *tlsCA != "" {
+		pem, err := os.ReadFile(*tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls-ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls-ca: no certificates found in %s", *tlsCA)
+		}
+		conf.RootCAs = pool
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls-cert/tls-key: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// dialTLSConfig returns the *tls.Config to present when dialing addr. If
+// ServerName is already pinned (--tls-servername), conf is reused as-is;
+// otherwise a clone is returned with ServerName set to addr's host, so each
+// backend in a --targets pool is verified against its own name rather than
+// a single name baked in at startup.
+func dialTLSConfig(conf *tls.Config, addr string) *tls.Config {
+	if conf.ServerName != "" {
+		return conf
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return conf
+	}
+	c := conf.Clone()
+	c.ServerName = host
+	return c
+}
+
+// parseTLSVersion maps a --tls-min-version flag value to its tls.VersionTLS* constant.
+func parseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("tls-min-version: unsupported version %q", v)
+	}
+}
+
+// tlsVersionName renders a tls.VersionTLS* constant the way --tls-min-version expects it.
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "1.0"
+	case tls.VersionTLS11:
+		return "1.1"
+	case tls.VersionTLS12:
+		return "1.2"
+	case tls.VersionTLS13:
+		return "1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
+
+// probeTLS makes a one-off connection to record the negotiated TLS
+// version and cipher suite for the CSV header, so results are
+// reproducible across runs.
+func probeTLS(conf *tls.Config, addr string) (version, cipher string) {
+	conn, err := tls.Dial("tcp", addr, conf)
+	if err != nil {
+		return "unknown", "unknown"
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	return tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)
+}
+
+// LatencyHistogram is a fixed-precision logarithmic bucket histogram used
+// to track session duration percentiles across the whole run. Samples are
+// folded in with a single atomic.AddUint64 per bucket, so it never locks
+// on the hot path.
+type LatencyHistogram struct {
+	buckets [histBuckets]uint64
+	count   uint64
+	min     int64
+	max     int64
+}
+
+// Percentiles is a point-in-time snapshot of a LatencyHistogram.
+type Percentiles struct {
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P95    time.Duration `json:"p95"`
+	P99    time.Duration `json:"p99"`
+	P999   time.Duration `json:"p999"`
+	Min    time.Duration `json:"min"`
+	Max    time.Duration `json:"max"`
+	StdDev time.Duration `json:"stddev"`
+	Count  uint64        `json:"count"`
+}
+
+// Add folds a session duration, in nanoseconds, into the histogram.
+func (h *LatencyHistogram) Add(ns int64) {
+	if ns < 1 {
+		ns = 1
+	}
+	atomic.AddUint64(&h.buckets[bucketIndex(ns)], 1)
+	atomic.AddUint64(&h.count, 1)
+	casMax(&h.max, ns)
+	casMin(&h.min, ns)
+}
+
+// Snapshot walks the buckets and computes the cumulative counts needed to
+// interpolate percentile boundaries, along with min, max and stddev.
+func (h *LatencyHistogram) Snapshot() Percentiles {
+	total := atomic.LoadUint64(&h.count)
+	snap := Percentiles{Count: total}
+	if total == 0 {
+		return snap
+	}
+	snap.Min = time.Duration(atomic.LoadInt64(&h.min))
+	snap.Max = time.Duration(atomic.LoadInt64(&h.max))
+	targets := [5]float64{0.50, 0.90, 0.95, 0.99, 0.999}
+	var results [5]time.Duration
+	var cum uint64
+	var sum, sumSq float64
+	ti := 0
+	for i := 0; i < histBuckets; i++ {
+		c := atomic.LoadUint64(&h.buckets[i])
+		if c == 0 {
+			continue
+		}
+		mid := bucketMidpoint(i)
+		sum += float64(c) * mid
+		sumSq += float64(c) * mid * mid
+		prev := cum
+		cum += c
+		lo := math.Exp2(float64(i) / histResolution)
+		hi := math.Exp2(float64(i+1) / histResolution)
+		for ti < len(targets) && float64(cum) >= targets[ti]*float64(total) {
+			// Interpolate linearly within the bucket by the rank's position
+			frac := (targets[ti]*float64(total) - float64(prev)) / float64(c)
+			results[ti] = time.Duration(lo + frac*(hi-lo))
+			ti++
+		}
+	}
+	for ; ti < len(targets); ti++ {
+		results[ti] = snap.Max
+	}
+	snap.P50, snap.P90, snap.P95, snap.P99, snap.P999 = results[0], results[1], results[2], results[3], results[4]
+	mean := sum / float64(total)
+	if variance := sumSq/float64(total) - mean*mean; variance > 0 {
+		snap.StdDev = time.Duration(math.Sqrt(variance))
+	}
+	return snap
+}
+
+// bucketIndex maps a duration in nanoseconds to its histogram bucket.
+func bucketIndex(ns int64) int {
+	idx := int(math.Log2(float64(ns)) * histResolution)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= histBuckets {
+		idx = histBuckets - 1
+	}
+	return idx
+}
+
+// bucketMidpoint returns the representative duration, in nanoseconds, for
+// the given bucket index.
+func bucketMidpoint(idx int) float64 {
+	lo := math.Exp2(float64(idx) / histResolution)
+	hi := math.Exp2(float64(idx+1) / histResolution)
+	return (lo + hi) / 2
+}
+
+// casMax atomically raises *addr to val if val is larger.
+func casMax(addr *int64, val int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if val <= old {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, old, val) {
+			return
+		}
+	}
+}
+
+// casMin atomically lowers *addr to val if val is smaller, treating the
+// zero value as "unset".
+func casMin(addr *int64, val int64) {
+	for {
+		old := atomic.LoadInt64(addr)
+		if old != 0 && val >= old {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, old, val) {
+			return
+		}
+	}
 }
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	flag.Parse()
+	if *pprofAddr != "" {
+		go func() {
+			log.Println("pprof error:", http.ListenAndServe(*pprofAddr, nil))
+		}()
+	}
+	started := time.Now()
 	wgMain := new(sync.WaitGroup)
 	wgMain.Add(1)
 	b := benchInit()
@@ -90,6 +862,9 @@ func main() {
 		}
 		b.Logger = bufio.NewWriter(file)
 		defer func() {
+			p := b.TimeHist.Snapshot()
+			b.Logger.WriteString(fmt.Sprintf("#Latency p50=%v,p90=%v,p95=%v,p99=%v,p99.9=%v,min=%v,max=%v,stddev=%v,count=%d\n",
+				p.P50, p.P90, p.P95, p.P99, p.P999, p.Min, p.Max, p.StdDev, p.Count))
 			b.Logger.WriteString("#Stopped benchmark at: " + time.Now().String() + "\n")
 			b.Logger.Flush()
 			file.Close()
@@ -97,7 +872,13 @@ func main() {
 		b.Logger.WriteString("#Started benchmark at: " + time.Now().String() + "\n")
 		b.Logger.WriteString(fmt.Sprintf("#Host: %v\n#Port: %v\n#Runs: %v\n", *host, *port, *runs))
 		b.Logger.WriteString(fmt.Sprintf("#Sessions: %v\n#Delay: %v\n#Request: %v\n", *sess, *delay, *req))
+		if *agiTLS {
+			addr := b.Targets.targets[0].Addr
+			version, cipher := probeTLS(dialTLSConfig(b.TLSConfig, addr), addr)
+			b.Logger.WriteString(fmt.Sprintf("#TLS: version=%s cipher=%s\n", version, cipher))
+		}
 		b.Logger.WriteString("#completed,active,duration\n")
+		b.Logger.WriteString("#sys,ts,cpu,rss,goros,fds,load1,load5,load15\n")
 	}
 	if *single {
 		// Run once with detailed console output
@@ -109,6 +890,11 @@ func main() {
 		atomic.StoreUint32(&b.Shutdown, 1)
 	}
 	wgMain.Wait()
+	if *resultsJSON != "" {
+		if err := writeResultsJSON(b, started, *resultsJSON); err != nil {
+			log.Println("Failed to write results JSON:", err)
+		}
+	}
 }
 
 // Initialize benchmark session
@@ -116,8 +902,53 @@ func benchInit() *Bench {
 	var confData Config
 	b := new(Bench)
 	b.LogChan = make(chan string, *sess*2)
-	b.TimeChan = make(chan int64, *sess*2)
-	b.RunDelay = time.Duration(1e9 / *runs) * time.Nanosecond
+	b.TimeHist = new(LatencyHistogram)
+	b.Profile = constantProfile{rate: *runs * float64(*sess)}
+	switch {
+	case *ramp != "":
+		if p, err := newRampProfile(*ramp); err != nil {
+			log.Println("Failed to parse ramp profile, using constant rate:", err)
+		} else {
+			b.Profile = p
+		}
+	case *step != "":
+		if p, err := newStepProfile(*step); err != nil {
+			log.Println("Failed to parse step profile, using constant rate:", err)
+		} else {
+			b.Profile = p
+		}
+	case *spike != "":
+		if p, err := newSpikeProfile(*spike); err != nil {
+			log.Println("Failed to parse spike profile, using constant rate:", err)
+		} else {
+			b.Profile = p
+		}
+	}
+	b.Retry = defaultRetryPolicy
+	if *retry != "" {
+		if p, err := newRetryPolicy(*retry); err != nil {
+			log.Println("Failed to parse retry policy, making a single dial attempt:", err)
+		} else {
+			b.Retry = p
+		}
+	}
+	targetSpec := *targets
+	if targetSpec == "" {
+		targetSpec = net.JoinHostPort(*host, *port)
+	}
+	pool, err := newTargetPool(targetSpec, *targetMode)
+	if err != nil {
+		log.Println("Failed to parse targets, falling back to --host/--port:", err)
+		pool, _ = newTargetPool(net.JoinHostPort(*host, *port), *targetMode)
+	}
+	b.Targets = pool
+	if *agiTLS {
+		tlsConf, err := buildTLSConfig()
+		if err != nil {
+			log.Fatalln("Failed to build TLS config:", err)
+		}
+		b.TLSConfig = tlsConf
+	}
 	b.ReplyDelay = time.Duration(*delay) * time.Millisecond
 	if *conf != "" {
 		// Parse config file
@@ -134,42 +965,63 @@ func benchInit() *Bench {
 	}
 	b.Env = agiInit(confData.AgiEnv)
 	b.Payload = confData.AgiPayload
+	if confData.AgiScript != nil {
+		script, err := compileScript(confData.AgiScript)
+		if err != nil {
+			log.Println("Failed to compile AGI script, ignoring it:", err)
+		} else {
+			b.Script = script
+		}
+	}
 	return b
 }
 
-// Run benchmark and gather data
+// Run benchmark and gather data. A single scheduler goroutine dispatches
+// arrivals according to b.Profile's instantaneous rate (open model) -
+// arrivals don't wait for prior sessions to finish, which surfaces the
+// server's actual saturation point instead of the driver's own concurrency.
 func agiBench(b *Bench, wg *sync.WaitGroup) {
 	defer wg.Done()
-	wgBench := new(sync.WaitGroup)
-	wgBench.Add(*sess)
-	// Spawn pool of parallel runs
-	for i := 0; i < *sess; i++ {
-		ticker := time.Tick(b.RunDelay)
-		go func() {
-			defer wgBench.Done()
-			wgConn := new(sync.WaitGroup)
-			for atomic.LoadUint32(&b.Shutdown) == 0 {
-				<-ticker
-				wgConn.Add(1)
-				go agiConnection(b, wgConn, false)
+	wgConn := new(sync.WaitGroup)
+	b.Started = time.Now()
+	wgSched := new(sync.WaitGroup)
+	wgSched.Add(1)
+	go func() {
+		defer wgSched.Done()
+		for atomic.LoadUint32(&b.Shutdown) == 0 {
+			rate := b.Profile.Rate(time.Since(b.Started))
+			if rate <= 0 {
+				time.Sleep(10 * time.Millisecond)
+				continue
 			}
-			wgConn.Wait()
-		}()
-	}
+			wgConn.Add(1)
+			go agiConnection(b, wgConn, false)
+			time.Sleep(time.Duration(float64(time.Second) / rate))
+		}
+	}()
+	// Sample driver-side resource usage; stops itself on shutdown, same as
+	// the scheduler, since it also writes to LogChan and must finish before
+	// that channel is closed below
+	wgSys := new(sync.WaitGroup)
+	wgSys.Add(1)
+	go func() {
+		defer wgSys.Done()
+		sysSampler(b)
+	}()
 	wgMon := new(sync.WaitGroup)
 	// Write to log file
 	if *debug {
 		wgMon.Add(1)
 		go logger(b, wgMon)
 	}
-	// Calculate session duration and display some output on the console
-	wgMon.Add(2)
-	go calcAvrg(b, wgMon)
+	// Display some output on the console
+	wgMon.Add(1)
 	go consoleOutput(b, wgMon)
-	// Wait all FastAGI sessions to end
-	wgBench.Wait()
+	// Wait for the scheduler and sampler to stop and all FastAGI sessions to end
+	wgSched.Wait()
+	wgConn.Wait()
+	wgSys.Wait()
 	close(b.LogChan)
-	close(b.TimeChan)
 	// Wait logger and console output to finish
 	wgMon.Wait()
 }
@@ -178,16 +1030,27 @@ func agiBench(b *Bench, wg *sync.WaitGroup) {
 func agiConnection(b *Bench, wg *sync.WaitGroup, consoleDb bool) {
 	defer wg.Done()
 	start := time.Now()
+	target := b.Targets.Pick()
 	var err error
 	var conn net.Conn
-	if *agiTLS {
-		tslConf := tls.Config{InsecureSkipVerify: true}
-		conn, err = tls.Dial("tcp", net.JoinHostPort(*host, *port), &tslConf)
-	} else {
-		conn, err = net.Dial("tcp", net.JoinHostPort(*host, *port))
+	for attempt := 0; attempt < b.Retry.MaxAttempts; attempt++ {
+		if *agiTLS {
+			conn, err = tls.Dial("tcp", target.Addr, dialTLSConfig(b.TLSConfig, target.Addr))
+		} else {
+			conn, err = net.Dial("tcp", target.Addr)
+		}
+		if err == nil || attempt == b.Retry.MaxAttempts-1 {
+			break
+		}
+		atomic.AddUint32(&b.Retries, 1)
+		time.Sleep(nextRetryDelay(b.Retry, attempt))
 	}
 	if err != nil {
 		atomic.AddUint32(&b.Fail, 1)
+		atomic.AddUint32(&target.Fail, 1)
+		if b.Retry.MaxAttempts > 1 {
+			atomic.AddUint32(&b.RetriedFail, 1)
+		}
 		if *debug {
 			b.LogChan <- fmt.Sprintf("# %s\n", err)
 		}
@@ -203,7 +1066,57 @@ func agiConnection(b *Bench, wg *sync.WaitGroup, consoleDb bool) {
 	if consoleDb {
 		fmt.Print("AGI Tx >>\n", string(b.Env))
 	}
-	if b.Payload == nil {
+	if b.Script != nil {
+		// Drive the connection with the stateful script engine: match each
+		// incoming command against the steps from the current one onward
+		// (unmatched commands get a plain success reply), expand its reply
+		// template from the accumulated Vars, and advance per Goto/Repeat
+		vars := make(map[string]string)
+		cursor := 0
+		repeats := 0
+		for scanner.Scan() {
+			if consoleDb {
+				fmt.Println("AGI Rx <<\n", scanner.Text())
+			}
+			if scanner.Text() == failure {
+				conn.Close()
+				atomic.AddUint32(&b.Fail, 1)
+				atomic.AddUint32(&target.Fail, 1)
+				atomic.AddInt32(&b.Active, -1)
+				return
+			}
+			if scanner.Text() == hangup {
+				conn.Write([]byte(hangupReply))
+				if consoleDb {
+					fmt.Println("AGI Tx >>\n ", hangupReply)
+				}
+				break
+			}
+			step, idx, matched := matchStep(b.Script, cursor, scanner.Text(), vars)
+			if !matched {
+				time.Sleep(b.ReplyDelay)
+				conn.Write([]byte(successReply))
+				if consoleDb {
+					fmt.Print("AGI Tx >>\n ", successReply)
+				}
+				continue
+			}
+			time.Sleep(time.Duration(step.Delay) * time.Millisecond)
+			reply := expandReply(step, vars)
+			conn.Write([]byte(reply + "\n"))
+			if consoleDb {
+				fmt.Print("AGI Tx >>\n ", reply+"\n")
+			}
+			switch {
+			case step.Goto != "":
+				cursor, repeats = b.Script.byName[step.Goto], 0
+			case step.Repeat > 0 && repeats < step.Repeat-1:
+				repeats++
+			default:
+				cursor, repeats = idx+1, 0
+			}
+		}
+	} else if b.Payload == nil {
 		// When no payload is defined reply with '200' to all messages from the AGI server until it hangs up
 		for scanner.Scan() {
 			if consoleDb {
@@ -212,6 +1125,7 @@ func agiConnection(b *Bench, wg *sync.WaitGroup, consoleDb bool) {
 			if scanner.Text() == failure {
 				conn.Close()
 				atomic.AddUint32(&b.Fail, 1)
+				atomic.AddUint32(&target.Fail, 1)
 				atomic.AddInt32(&b.Active, -1)
 				return
 			}
@@ -240,6 +1154,7 @@ func agiConnection(b *Bench, wg *sync.WaitGroup, consoleDb bool) {
 			if scanner.Text() == failure {
 				conn.Close()
 				atomic.AddUint32(&b.Fail, 1)
+				atomic.AddUint32(&target.Fail, 1)
 				atomic.AddInt32(&b.Active, -1)
 				return
 			}
@@ -259,9 +1174,11 @@ func agiConnection(b *Bench, wg *sync.WaitGroup, consoleDb bool) {
 	}
 	conn.Close()
 	elapsed := time.Since(start)
-	b.TimeChan <- elapsed.Nanoseconds()
+	b.TimeHist.Add(elapsed.Nanoseconds())
 	atomic.AddInt32(&b.Active, -1)
 	atomic.AddUint32(&b.Count, 1)
+	atomic.AddUint32(&target.Count, 1)
+	target.Hist.Add(elapsed.Nanoseconds())
 	if *debug {
 		b.LogChan <- fmt.Sprintf("%d,%d,%d\n", atomic.LoadUint32(&b.Count),
 			atomic.LoadInt32(&b.Active), elapsed.Nanoseconds())
@@ -279,35 +1196,35 @@ func logger(b *Bench, wg *sync.WaitGroup) {
 	}
 }
 
-// Calculate Average session duration for the last 10000 sessions
-func calcAvrg(b *Bench, wg *sync.WaitGroup) {
-	defer wg.Done()
-	var sessions int64
-	for dur := range b.TimeChan {
-		sessions++
-		atomic.StoreInt64(&b.AvrDur, (atomic.LoadInt64(&b.AvrDur)*(sessions-1)+dur)/sessions)
-		if sessions >= 10000 {
-			sessions = 0
-		}
-	}
-}
-
 // Display pretty output to the user
 func consoleOutput(b *Bench, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for {
 		fmt.Print("\033[2J\033[H") //Clear screen
-		fmt.Println("Running FastAGI bench against:", net.JoinHostPort(*host, *port),
+		fmt.Println("Running FastAGI bench against", len(b.Targets.targets), "target(s), mode:", b.Targets.mode,
 			"\nPress Enter to stop.\n",
-			"\nA new run each:", b.RunDelay,
-			"\nSessions per run:", *sess)
+			"\nTarget rate:", b.Profile.Rate(time.Since(b.Started)), "sessions/s")
 		if b.Payload == nil {
 			fmt.Println("Reply delay:", b.ReplyDelay)
 		}
+		p := b.TimeHist.Snapshot()
 		fmt.Println("\n\nFastAGI Sessions\nActive:", atomic.LoadInt32(&b.Active),
 			"\nCompleted:", atomic.LoadUint32(&b.Count),
-			"\nDuration:", atomic.LoadInt64(&b.AvrDur), "ns (last 10000 sessions average)",
 			"\nFailed:", atomic.LoadUint32(&b.Fail))
+		if b.Retry.MaxAttempts > 1 {
+			fmt.Println("Retries:", atomic.LoadUint32(&b.Retries),
+				"\nFailed after retries:", atomic.LoadUint32(&b.RetriedFail))
+		}
+		fmt.Printf("\nLatency  p50: %v  p90: %v  p95: %v  p99: %v  p99.9: %v\n"+
+			"Min: %v  Max: %v  StdDev: %v\n",
+			p.P50, p.P90, p.P95, p.P99, p.P999, p.Min, p.Max, p.StdDev)
+		if sys, ok := b.SysStats.Load().(SysSample); ok {
+			fmt.Printf("\nDriver   CPU: %.1f%%  RSS: %d MB  Goroutines: %d  FDs: %d"+
+				"  Load: %.2f %.2f %.2f\n",
+				sys.CPUPercent, sys.RSS/1024/1024, sys.Goroutines, sys.FDs,
+				sys.Load1, sys.Load5, sys.Load15)
+		}
+		printTargetTable(b.Targets)
 		if atomic.LoadUint32(&b.Shutdown) != 0 {
 			fmt.Println("Stopping...")
 			if atomic.LoadInt32(&b.Active) <= 0 {
@@ -318,6 +1235,115 @@ func consoleOutput(b *Bench, wg *sync.WaitGroup) {
 	}
 }
 
+// printTargetTable shows per-backend counters when more than one target
+// is configured, so a pool or an A/B comparison can be read at a glance.
+func printTargetTable(pool *TargetPool) {
+	if len(pool.targets) < 2 {
+		return
+	}
+	fmt.Println("\nTargets")
+	for _, t := range pool.targets {
+		p := t.Hist.Snapshot()
+		fmt.Printf("  %-22s weight=%-3d count=%-6d fail=%-6d p95=%v\n",
+			t.Addr, t.Weight, atomic.LoadUint32(&t.Count), atomic.LoadUint32(&t.Fail), p.P95)
+	}
+}
+
+// ResultsReport is the schema written by --results-json, a versioned,
+// machine-readable summary of a completed run for CI harnesses to diff or
+// plot over time without scraping the console output.
+type ResultsReport struct {
+	Schema      string         `json:"schema"`
+	Config      ResultsConfig  `json:"config"`
+	StartedAt   time.Time      `json:"started_at"`
+	DurationSec float64        `json:"duration_sec"`
+	Completed   uint32         `json:"completed"`
+	Failed      uint32         `json:"failed"`
+	Retries     uint32         `json:"retries,omitempty"`
+	RetriedFail uint32         `json:"retried_fail,omitempty"`
+	Latency     Percentiles    `json:"latency"`
+	Targets     []TargetReport `json:"targets,omitempty"`
+	Sys         *SysSample     `json:"sys,omitempty"`
+}
+
+// ResultsConfig echoes the knobs that shaped the run, so a diff between two
+// reports can tell a real regression from a change in test parameters.
+type ResultsConfig struct {
+	Host          string  `json:"host"`
+	Port          string  `json:"port"`
+	Runs          float64 `json:"runs"`
+	Sessions      int     `json:"sessions"`
+	Request       string  `json:"request"`
+	Ramp          string  `json:"ramp,omitempty"`
+	Step          string  `json:"step,omitempty"`
+	Spike         string  `json:"spike,omitempty"`
+	Retry         string  `json:"retry,omitempty"`
+	Targets       string  `json:"targets,omitempty"`
+	TargetMode    string  `json:"target_mode"`
+	TLS           bool    `json:"tls"`
+	TLSInsecure   bool    `json:"tls_insecure,omitempty"`
+	TLSMinVersion string  `json:"tls_min_version,omitempty"`
+}
+
+// TargetReport is one backend's counters and latency within a multi-target run.
+type TargetReport struct {
+	Addr    string      `json:"addr"`
+	Weight  int         `json:"weight"`
+	Count   uint32      `json:"count"`
+	Fail    uint32      `json:"fail"`
+	Latency Percentiles `json:"latency"`
+}
+
+// writeResultsJSON assembles a ResultsReport from the finished Bench and
+// writes it to path.
+func writeResultsJSON(b *Bench, started time.Time, path string) error {
+	report := ResultsReport{
+		Schema: "agistress/v1",
+		Config: ResultsConfig{
+			Host:          *host,
+			Port:          *port,
+			Runs:          *runs,
+			Sessions:      *sess,
+			Request:       *req,
+			Ramp:          *ramp,
+			Step:          *step,
+			Spike:         *spike,
+			Retry:         *retry,
+			Targets:       *targets,
+			TargetMode:    *targetMode,
+			TLS:           *agiTLS,
+			TLSInsecure:   *tlsInsecure,
+			TLSMinVersion: *tlsMinVersion,
+		},
+		StartedAt:   started,
+		DurationSec: time.Since(started).Seconds(),
+		Completed:   atomic.LoadUint32(&b.Count),
+		Failed:      atomic.LoadUint32(&b.Fail),
+		Retries:     atomic.LoadUint32(&b.Retries),
+		RetriedFail: atomic.LoadUint32(&b.RetriedFail),
+		Latency:     b.TimeHist.Snapshot(),
+	}
+	if sys, ok := b.SysStats.Load().(SysSample); ok {
+		report.Sys = &sys
+	}
+	if len(b.Targets.targets) > 1 {
+		for _, t := range b.Targets.targets {
+			report.Targets = append(report.Targets, TargetReport{
+				Addr:    t.Addr,
+				Weight:  t.Weight,
+				Count:   atomic.LoadUint32(&t.Count),
+				Fail:    atomic.LoadUint32(&t.Fail),
+				Latency: t.Hist.Snapshot(),
+			})
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 // Generate AGI Environment data
 func agiInit(env []string) []byte {
 	var envData string